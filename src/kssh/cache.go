@@ -0,0 +1,279 @@
+package kssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/bot-ssh-ca/src/shared"
+)
+
+// DefaultCacheTTL is how long a cached team discovery result (see cacheFile) is trusted before
+// LoadConfigs falls back to rescanning every ConfigSource. Overridden per-user via
+// LocalConfigFile.CacheTTLSeconds.
+const DefaultCacheTTL = 10 * time.Minute
+
+// refreshBeforeExpiry is how close to TTL expiry a cache entry has to get before LoadConfigs starts
+// an async refresh in the background while still serving the (still-fresh) cached result.
+const refreshBeforeExpiry = 2 * time.Minute
+
+// backgroundRefreshGrace bounds how long loadConfigsCached will wait for a background refresh to
+// land before returning anyway. kssh is a one-shot CLI process, not a daemon: a goroutine started
+// with `go` and never waited on would typically be killed by process exit before a multi-team KBFS
+// rescan finishes, so the "async" refresh would never actually persist. Waiting gives it a real
+// chance to complete without turning every near-expiry call back into the fully synchronous path.
+//
+// This is deliberately set well below refreshBeforeExpiry (rather than sized to any particular scan
+// duration, which varies enormously with team count): for users with a handful of teams it is enough
+// for the refresh to reliably land well before the cache actually expires; for users in very many
+// teams a single invocation may still see the refresh time out here and simply retry on a later
+// invocation (refreshAttemptCooldown paces those retries) until either one lands in time or the cache
+// expires and LoadConfigs falls back to the synchronous rescan it always used to do. That fallback is
+// no worse than kssh's pre-caching behavior, so an occasional missed background refresh is an
+// accepted trade-off rather than a regression.
+const backgroundRefreshGrace = 10 * time.Second
+
+// refreshAttemptCooldown bounds how often separate kssh invocations will retry a background refresh
+// for the same cache entry. Without it, every invocation during the refreshBeforeExpiry window would
+// independently pay up to backgroundRefreshGrace in extra latency (eg once per ssh connection, when
+// kssh is used as a ProxyCommand) even though only one of them needs to actually land the refresh.
+const refreshAttemptCooldown = 20 * time.Second
+
+// refreshAttemptMarkerLocation records (via its mtime) when a background refresh was last attempted,
+// so that refreshAttemptCooldown can be enforced across separate kssh processes.
+var refreshAttemptMarkerLocation = kesshCacheFileLocation + ".refreshing"
+
+// kesshCacheFileLocation is where the resolved team discovery results are cached between invocations.
+var kesshCacheFileLocation = shared.ExpandPathWithTilde("~/.ssh/kssh.cache.json")
+
+// cacheFile is the on-disk shape of ~/.ssh/kssh.cache.json.
+type cacheFile struct {
+	// TeamsHash invalidates the cache if the user's KBFS team membership has changed since it was written.
+	TeamsHash string        `json:"teams_hash"`
+	Configs   []ConfigFile  `json:"configs"`
+	Botnames  []string      `json:"botnames"`
+	CachedAt  time.Time     `json:"cached_at"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+func (c cacheFile) expiresAt() time.Time {
+	return c.CachedAt.Add(c.TTL)
+}
+
+func (c cacheFile) isFresh(now time.Time) bool {
+	return now.Before(c.expiresAt())
+}
+
+func (c cacheFile) needsBackgroundRefresh(now time.Time) bool {
+	return now.Add(refreshBeforeExpiry).After(c.expiresAt())
+}
+
+// refreshInFlight prevents piling up duplicate background refreshes for the same team list while one
+// is already running.
+var refreshInFlight sync.Map // map[string]struct{}, keyed by teamsHash
+
+// loadConfigsCached returns a fresh cache.Configs/Botnames immediately when available. A cache entry
+// nearing expiry is still returned from this call, but first kicks off a background refresh and
+// gives it up to backgroundRefreshGrace to land on disk, so that the *next* call is fast and up to
+// date. A missing/stale/corrupt cache falls back to a synchronous refresh.
+func loadConfigsCached() ([]ConfigFile, []string, error) {
+	teamsHash, err := currentTeamsHash()
+	if err != nil {
+		// Team discovery itself is unavailable (eg no KBFS); fall back to an uncached load so callers
+		// see the real underlying error instead of one about the cache.
+		return loadConfigsFromSources()
+	}
+
+	if cached, ok := readCache(); ok && cached.TeamsHash == teamsHash {
+		now := time.Now()
+		if cached.isFresh(now) {
+			if cached.needsBackgroundRefresh(now) {
+				waitForBackgroundRefresh(teamsHash)
+			}
+			return cached.Configs, cached.Botnames, nil
+		}
+	}
+
+	return refreshCache(teamsHash)
+}
+
+// RefreshCache forces a synchronous, unconditional cache refresh. It backs `kssh --refresh-cache`.
+func RefreshCache() ([]ConfigFile, []string, error) {
+	teamsHash, err := currentTeamsHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	return refreshCache(teamsHash)
+}
+
+func refreshCache(teamsHash string) ([]ConfigFile, []string, error) {
+	configs, botnames, err := loadConfigsFromSources()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeCache(cacheFile{
+		TeamsHash: teamsHash,
+		Configs:   configs,
+		Botnames:  botnames,
+		CachedAt:  time.Now(),
+		TTL:       cacheTTL(),
+	})
+	if err != nil {
+		// A cache write failure shouldn't fail the caller; they already have a good result, just an
+		// uncached one. The next invocation will simply refresh again.
+		return configs, botnames, nil
+	}
+	return configs, botnames, nil
+}
+
+// waitForBackgroundRefresh kicks off an async refresh of the cache for teamsHash (deduplicated via
+// refreshInFlight) and blocks for up to backgroundRefreshGrace to let it finish before returning, so
+// that the refresh has a real chance to persist before a one-shot kssh invocation exits.
+func waitForBackgroundRefresh(teamsHash string) {
+	if _, alreadyRunning := refreshInFlight.LoadOrStore(teamsHash, struct{}{}); alreadyRunning {
+		return
+	}
+
+	if recentlyAttemptedRefresh() {
+		// Another kssh invocation already kicked off a refresh within refreshAttemptCooldown; don't
+		// pile on a duplicate full rescan on every single invocation, just keep serving the cache.
+		refreshInFlight.Delete(teamsHash)
+		return
+	}
+	markRefreshAttempted()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer refreshInFlight.Delete(teamsHash)
+		_, _, _ = refreshCache(teamsHash)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(backgroundRefreshGrace):
+		// The refresh is still running; let it keep going in the background on a best-effort basis,
+		// but don't hold up this invocation any longer.
+	}
+}
+
+func recentlyAttemptedRefresh() bool {
+	info, err := os.Stat(refreshAttemptMarkerLocation)
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) < refreshAttemptCooldown
+}
+
+func markRefreshAttempted() {
+	_ = ioutil.WriteFile(refreshAttemptMarkerLocation, []byte{}, 0600)
+}
+
+func cacheTTL() time.Duration {
+	lcf, err := getCurrentConfig()
+	if err != nil || lcf.CacheTTLSeconds <= 0 {
+		return DefaultCacheTTL
+	}
+	return time.Duration(lcf.CacheTTLSeconds) * time.Second
+}
+
+// currentTeamsHash returns a short hash of the user's current KBFS team list, used to invalidate the
+// cache if the user joins/leaves a team running the CA bot.
+func currentTeamsHash() (string, error) {
+	teams, err := shared.KBFSList("/keybase/team/")
+	if err != nil {
+		return "", fmt.Errorf("failed to list KBFS teams: %v", err)
+	}
+	sort.Strings(teams)
+	sum := sha256.Sum256([]byte(strings.Join(teams, ",")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readCache() (cacheFile, bool) {
+	var cf cacheFile
+	unlock, err := lockCacheFile()
+	if err != nil {
+		return cf, false
+	}
+	defer unlock()
+
+	bytes, err := ioutil.ReadFile(kesshCacheFileLocation)
+	if err != nil {
+		return cf, false
+	}
+	if err := json.Unmarshal(bytes, &cf); err != nil {
+		return cf, false
+	}
+	return cf, true
+}
+
+func writeCache(cf cacheFile) error {
+	unlock, err := lockCacheFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	bytes, err := json.Marshal(&cf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache file: %v", err)
+	}
+	if err := MakeDotSSH(); err != nil {
+		return err
+	}
+	if err := writeFileIfChanged(kesshCacheFileLocation, bytes, 0600); err != nil {
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+	return nil
+}
+
+// staleLockAge is how old a lock file's mtime has to be before a new invocation will assume the
+// process that created it died without cleaning up (eg Ctrl-C, OOM-kill, crash) and steal it, rather
+// than leaving kssh permanently broken until a human deletes it by hand. The lock is only held while
+// readCache/writeCache access the cache file (the refresh itself runs outside it), so it is set well
+// above how long any real holder should keep it.
+const staleLockAge = 30 * time.Second
+
+// lockCacheFile takes a simple cross-process lock (via exclusive creation of a sentinel file) so that
+// concurrent kssh invocations don't race on reading/writing kssh.cache.json. Returns an unlock func
+// that must always be called.
+func lockCacheFile() (unlock func(), err error) {
+	if err := MakeDotSSH(); err != nil {
+		return nil, err
+	}
+
+	lockPath := kesshCacheFileLocation + ".lock"
+	deadline := time.Now().Add(5 * time.Second)
+	stoleStaleLock := false
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create cache lock file: %v", err)
+		}
+
+		if !stoleStaleLock {
+			if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+				os.Remove(lockPath)
+				stoleStaleLock = true
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for kssh cache lock at %s", lockPath)
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+}
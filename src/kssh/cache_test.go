@@ -0,0 +1,33 @@
+package kssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheFileIsFresh(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cf := cacheFile{CachedAt: now, TTL: DefaultCacheTTL}
+
+	if !cf.isFresh(now.Add(DefaultCacheTTL - time.Second)) {
+		t.Errorf("expected cache to be fresh just before TTL expiry")
+	}
+	if cf.isFresh(now.Add(DefaultCacheTTL + time.Second)) {
+		t.Errorf("expected cache to be stale just after TTL expiry")
+	}
+}
+
+func TestCacheFileNeedsBackgroundRefresh(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cf := cacheFile{CachedAt: now, TTL: DefaultCacheTTL}
+
+	wellBeforeExpiry := now.Add(DefaultCacheTTL - refreshBeforeExpiry - time.Minute)
+	if cf.needsBackgroundRefresh(wellBeforeExpiry) {
+		t.Errorf("expected no refresh needed well before expiry")
+	}
+
+	nearExpiry := now.Add(DefaultCacheTTL - refreshBeforeExpiry + time.Second)
+	if !cf.needsBackgroundRefresh(nearExpiry) {
+		t.Errorf("expected a refresh to be needed within refreshBeforeExpiry of expiry")
+	}
+}
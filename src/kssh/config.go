@@ -18,57 +18,68 @@ type ConfigFile struct {
 	BotName     string `json:"botname"`
 }
 
-// LoadConfigs loads client configs from KBFS. Returns a (listOfConfigFiles, listOfBotNames, err)
+// LoadConfigs loads client configs, preferring a fresh entry from the on-disk team discovery cache
+// (see cache.go) over re-scanning every ConfigSource. Returns a (listOfConfigFiles, listOfBotNames, err)
 // Both lists are deduplicated based on ConfigFile.BotName
 func LoadConfigs() ([]ConfigFile, []string, error) {
-	allTeamsFromKBFS, err := shared.KBFSList("/keybase/team/")
+	return loadConfigsCached()
+}
+
+// loadConfigsFromSources loads client configs from every configured ConfigSource (KBFS plus any
+// extra sources listed in LocalConfigFile.ConfigSources), without consulting the cache. Sources are
+// queried in parallel (rather than KBFS-then-the-rest), so a call takes as long as its slowest source
+// rather than the sum of all of them. Nothing is returned until every source has finished; a slow
+// KBFS team listing is cut off by kbfsListTimeout.
+func loadConfigsFromSources() ([]ConfigFile, []string, error) {
+	sources, err := getConfigSources()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to load config file(s): %v", err)
 	}
 
-	// Iterate through the listed files in parallel to speed up kssh for users with lots of teams
-	semaphore := sync.WaitGroup{}
-	semaphore.Add(len(allTeamsFromKBFS))
-	boundChan := make(chan interface{}, shared.BoundedParallelismLimit)
-	errors := make(chan error, len(allTeamsFromKBFS))
-	botNameToConfig := make(map[string]ConfigFile)
-	botNameToConfigMutex := sync.Mutex{}
-	for _, team := range allTeamsFromKBFS {
-		go func(team string) {
-			// Blocks until there is room in boundChan
-			boundChan <- 0
-
-			filename := fmt.Sprintf("/keybase/team/%s/%s", team, shared.ConfigFilename)
-			exists, err := shared.KBFSFileExists(filename)
+	type sourceResult struct {
+		configs []ConfigFile
+		err     error
+	}
+	results := make([]sourceResult, len(sources))
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for i, source := range sources {
+		go func(i int, source ConfigSource) {
+			defer wg.Done()
+			paths, err := source.List()
 			if err != nil {
-				// Treat an error as it not existing and just skip that team while searching for config files
-				exists = false
-			}
-			if exists {
-				conf, err := LoadConfig(filename)
-				if err != nil {
-					errors <- err
-				} else {
-					botNameToConfigMutex.Lock()
-					botNameToConfig[conf.BotName] = conf
-					botNameToConfigMutex.Unlock()
-				}
+				results[i] = sourceResult{err: err}
+				return
 			}
+			configs, err := readConfigsFromSource(source, paths)
+			results[i] = sourceResult{configs: configs, err: err}
+		}(i, source)
+	}
+	wg.Wait()
 
-			semaphore.Done()
+	botNameToConfig := make(map[string]ConfigFile)
+	var sourceErrs []string
+	for _, result := range results {
+		if result.err != nil {
+			// Don't let one misbehaving source (eg a slow/unmounted KBFS) take down the whole
+			// lookup; keep trying the rest and only fail if every source failed.
+			sourceErrs = append(sourceErrs, result.err.Error())
+			continue
+		}
 
-			// Make room in boundChan
-			<-boundChan
-		}(team)
+		for _, conf := range result.configs {
+			// The first source to mention a bot wins. results is in the same order as sources, and
+			// KBFS is always first in getConfigSources, so an operator-distributed config_sources
+			// entry can only add bots, not override KBFS ones, even though sources ran concurrently.
+			if _, exists := botNameToConfig[conf.BotName]; !exists {
+				botNameToConfig[conf.BotName] = conf
+			}
+		}
 	}
-	semaphore.Wait()
 
-	// Read from errors without blocking
-	select {
-	case err := <-errors:
-		return nil, nil, err
-	default:
-		// No error
+	if len(sourceErrs) == len(sources) {
+		return nil, nil, fmt.Errorf("failed to load config file(s): %v", strings.Join(sourceErrs, "; "))
 	}
 
 	var configs []ConfigFile
@@ -81,23 +92,23 @@ func LoadConfigs() ([]ConfigFile, []string, error) {
 	return configs, botnames, nil
 }
 
+// LoadConfig loads a single ConfigFile directly out of KBFS. Kept for callers that already have a
+// specific KBFS path in hand; LoadConfigs should be preferred when discovering configs generically.
 func LoadConfig(kbfsFilename string) (ConfigFile, error) {
 	var cf ConfigFile
-	if !strings.HasPrefix(kbfsFilename, "/keybase/") {
-		return cf, fmt.Errorf("cannot load a kssh config from outside of KBFS")
-	}
-	bytes, err := shared.KBFSRead(kbfsFilename)
+	source := kbfsConfigSource{}
+	bytes, err := source.Read(kbfsFilename)
 	if err != nil {
 		return cf, fmt.Errorf("found a config file at %s that could not be read: %v", kbfsFilename, err)
 	}
-	err = json.Unmarshal(bytes, &cf)
+	configs, err := parseConfigBytes(kbfsFilename, bytes)
 	if err != nil {
-		return cf, fmt.Errorf("failed to parse config file at %s: %v", kbfsFilename, err)
+		return cf, err
 	}
-	if cf.TeamName == "" || cf.BotName == "" {
-		return cf, fmt.Errorf("found a config file at %s that is missing data: %s", kbfsFilename, string(bytes))
+	if len(configs) == 0 {
+		return cf, fmt.Errorf("found a config file at %s that did not contain any configs", kbfsFilename)
 	}
-	return cf, err
+	return configs[0], nil
 }
 
 // A LocalConfigFile is a file that lives on the FS of the computer running kssh. It is only used if the user is
@@ -110,6 +121,30 @@ type LocalConfigFile struct {
 	DefaultBotName string `json:"default_bot"`
 	DefaultBotTeam string `json:"default_team"`
 	DefaultSSHUser string `json:"default_ssh_user"`
+
+	// ConfigSources lists extra places (beyond KBFS) to look for ConfigFile entries, as
+	// "file://" or "https://" URLs. See getConfigSources/newConfigSourceFromURL.
+	ConfigSources []string `json:"config_sources"`
+
+	// CacheTTLSeconds overrides how long ~/.ssh/kssh.cache.json is trusted before LoadConfigs falls
+	// back to rescanning every ConfigSource. 0 (the default) means DefaultCacheTTL. See cache.go.
+	CacheTTLSeconds int `json:"cache_ttl_seconds"`
+
+	// BotOverrides customizes how kssh connects to a specific bot/team, keyed by botname, for users
+	// who are in multiple teams with different conventions. See SetBotOverride/ResolveBotOverride.
+	BotOverrides map[string]BotOverride `json:"bot_overrides"`
+}
+
+// A BotOverride customizes how kssh connects to a specific bot/team. Any zero-valued field falls
+// back to kssh's usual default for that field (eg DefaultSSHUser for SSHUser).
+type BotOverride struct {
+	SSHUser string `json:"ssh_user,omitempty"`
+	// SSHOptions are extra `-o` flags to pass to ssh, eg "StrictHostKeyChecking=no".
+	SSHOptions []string `json:"ssh_options,omitempty"`
+	// JumpHost is passed to ssh as `-J` when set.
+	JumpHost string `json:"jump_host,omitempty"`
+	// KeygenAlgorithm selects the key type kssh requests a certificate for, eg "ed25519" or "rsa".
+	KeygenAlgorithm string `json:"keygen_algorithm,omitempty"`
 }
 
 // Where to store the local config file. Just stash it in ~/.ssh
@@ -125,19 +160,105 @@ func GetDefaultSSHUser() (string, error) {
 }
 
 func SetDefaultSSHUser(username string) error {
+	if err := validateSSHUsername(username); err != nil {
+		return err
+	}
+
+	lcf, err := getCurrentConfig()
+	if err != nil {
+		return err
+	}
+
+	lcf.DefaultSSHUser = username
+	return writeConfig(lcf)
+}
+
+func validateSSHUsername(username string) error {
 	if strings.ContainsAny(username, " \t\n\r'\"") {
 		return fmt.Errorf("invalid username: %s", username)
 	}
+	return nil
+}
+
+// validateJumpHost applies the same restriction as validateSSHUsername: JumpHost is passed to ssh as
+// a bare `-J` argument (see BotOverride.JumpHost), so it must not contain whitespace or quotes either.
+func validateJumpHost(jumpHost string) error {
+	if strings.ContainsAny(jumpHost, " \t\n\r'\"") {
+		return fmt.Errorf("invalid jump_host: %s", jumpHost)
+	}
+	return nil
+}
+
+// validateSSHOption checks a single BotOverride.SSHOptions entry. Each entry becomes its own `-o`
+// argument, so (unlike JumpHost) a space within the value is fine (eg `ProxyCommand=ssh -W %h:%p x`),
+// but newlines/quotes are rejected since they have no legitimate use in a `-o` value and could be used
+// to smuggle extra arguments or ssh_config directives.
+func validateSSHOption(option string) error {
+	if strings.ContainsAny(option, "\n\r'\"") {
+		return fmt.Errorf("invalid ssh_options entry: %s", option)
+	}
+	return nil
+}
+
+// SetBotOverride sets the BotOverride for botname, replacing any existing override for it.
+// Controlled via `kssh --set-bot-override foo ssh_user=ubuntu`.
+func SetBotOverride(botname string, o BotOverride) error {
+	if botname == "" {
+		return fmt.Errorf("botname must not be empty")
+	}
+	if o.SSHUser != "" {
+		if err := validateSSHUsername(o.SSHUser); err != nil {
+			return err
+		}
+	}
+	if o.JumpHost != "" {
+		if err := validateJumpHost(o.JumpHost); err != nil {
+			return err
+		}
+	}
+	for _, option := range o.SSHOptions {
+		if err := validateSSHOption(option); err != nil {
+			return err
+		}
+	}
 
 	lcf, err := getCurrentConfig()
 	if err != nil {
 		return err
 	}
 
-	lcf.DefaultSSHUser = username
+	if lcf.BotOverrides == nil {
+		lcf.BotOverrides = make(map[string]BotOverride)
+	}
+	lcf.BotOverrides[botname] = o
 	return writeConfig(lcf)
 }
 
+// GetBotOverride returns the raw BotOverride configured for botname, without falling back to any
+// global defaults. Use ResolveBotOverride to get the effective, fallback-applied settings.
+func GetBotOverride(botname string) (BotOverride, error) {
+	lcf, err := getCurrentConfig()
+	if err != nil {
+		return BotOverride{}, err
+	}
+	return lcf.BotOverrides[botname], nil
+}
+
+// ResolveBotOverride returns the BotOverride that kssh should actually use to connect to botname,
+// falling back to DefaultSSHUser when the bot has no override (or its override leaves SSHUser unset).
+func ResolveBotOverride(botname string) (BotOverride, error) {
+	lcf, err := getCurrentConfig()
+	if err != nil {
+		return BotOverride{}, err
+	}
+
+	resolved := lcf.BotOverrides[botname]
+	if resolved.SSHUser == "" {
+		resolved.SSHUser = lcf.DefaultSSHUser
+	}
+	return resolved, nil
+}
+
 func writeConfig(lcf LocalConfigFile) error {
 	bytes, err := json.Marshal(&lcf)
 	if err != nil {
@@ -0,0 +1,82 @@
+package kssh
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// withTempLocalConfig points localConfigFileLocation at a fresh file under t.TempDir() for the
+// duration of the test, so tests don't touch the real ~/.ssh/kssh.config.
+func withTempLocalConfig(t *testing.T) {
+	t.Helper()
+	original := localConfigFileLocation
+	localConfigFileLocation = filepath.Join(t.TempDir(), "kssh.config")
+	t.Cleanup(func() { localConfigFileLocation = original })
+}
+
+func TestResolveBotOverrideFallsBackToDefaultSSHUser(t *testing.T) {
+	withTempLocalConfig(t)
+
+	if err := SetDefaultSSHUser("defaultuser"); err != nil {
+		t.Fatalf("SetDefaultSSHUser: %v", err)
+	}
+	if err := SetBotOverride("bot-with-override", BotOverride{SSHUser: "botuser"}); err != nil {
+		t.Fatalf("SetBotOverride: %v", err)
+	}
+
+	withOverride, err := ResolveBotOverride("bot-with-override")
+	if err != nil {
+		t.Fatalf("ResolveBotOverride: %v", err)
+	}
+	if withOverride.SSHUser != "botuser" {
+		t.Errorf("ResolveBotOverride(bot-with-override).SSHUser = %q, want %q", withOverride.SSHUser, "botuser")
+	}
+
+	withoutOverride, err := ResolveBotOverride("bot-with-no-override")
+	if err != nil {
+		t.Fatalf("ResolveBotOverride: %v", err)
+	}
+	if withoutOverride.SSHUser != "defaultuser" {
+		t.Errorf("ResolveBotOverride(bot-with-no-override).SSHUser = %q, want %q", withoutOverride.SSHUser, "defaultuser")
+	}
+}
+
+func TestSetBotOverrideRejectsInvalidFields(t *testing.T) {
+	withTempLocalConfig(t)
+
+	tests := []struct {
+		name string
+		o    BotOverride
+	}{
+		{"ssh_user with space", BotOverride{SSHUser: "bad user"}},
+		{"jump_host with space", BotOverride{JumpHost: "bad host"}},
+		{"jump_host with quote", BotOverride{JumpHost: `bad"host`}},
+		{"ssh_options with newline", BotOverride{SSHOptions: []string{"Foo=bar\nEvil=yes"}}},
+	}
+	for _, tc := range tests {
+		if err := SetBotOverride("somebot", tc.o); err == nil {
+			t.Errorf("SetBotOverride(%s) = nil error, want an error", tc.name)
+		}
+	}
+}
+
+func TestSetBotOverrideAcceptsValidFields(t *testing.T) {
+	withTempLocalConfig(t)
+
+	o := BotOverride{
+		SSHUser:    "ubuntu",
+		JumpHost:   "bastion.example.com",
+		SSHOptions: []string{"StrictHostKeyChecking=no", "ProxyCommand=ssh -W %h:%p bastion"},
+	}
+	if err := SetBotOverride("somebot", o); err != nil {
+		t.Fatalf("SetBotOverride: %v", err)
+	}
+
+	got, err := GetBotOverride("somebot")
+	if err != nil {
+		t.Fatalf("GetBotOverride: %v", err)
+	}
+	if got.JumpHost != o.JumpHost {
+		t.Errorf("GetBotOverride().JumpHost = %q, want %q", got.JumpHost, o.JumpHost)
+	}
+}
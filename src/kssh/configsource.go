@@ -0,0 +1,271 @@
+package kssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/bot-ssh-ca/src/shared"
+)
+
+// httpConfigSourceTimeout bounds how long a single config_sources HTTPS fetch is allowed to take, so
+// that a slow/hanging endpoint can't hang kssh indefinitely.
+const httpConfigSourceTimeout = 10 * time.Second
+
+// kbfsListTimeout bounds how long the initial KBFS team listing is allowed to take. loadConfigsFromSources
+// waits for every source (KBFS included) before returning, so without a bound a merely-slow (not
+// hard-erroring) KBFS would still fully block the results of the fast file://https:// sources that
+// config_sources exists to let operators fall back to. Only the team listing is bounded; the
+// per-team existence checks and reads that follow it are not.
+const kbfsListTimeout = 10 * time.Second
+
+// A ConfigSource knows how to enumerate and fetch raw ConfigFile bytes from one place that
+// keybaseca might have published them to. LoadConfigs merges the results of every configured
+// ConfigSource, deduplicated by ConfigFile.BotName.
+type ConfigSource interface {
+	// List returns the set of paths (in whatever addressing scheme the source uses) that may
+	// contain config data.
+	List() ([]string, error)
+	// Read returns the raw bytes found at a path previously returned by List.
+	Read(path string) ([]byte, error)
+}
+
+// kbfsConfigSource is the original (and default) backend: every team the user is in is scanned for
+// a shared.ConfigFilename file written by the keybaseca server process.
+type kbfsConfigSource struct{}
+
+func (kbfsConfigSource) List() ([]string, error) {
+	allTeamsFromKBFS, err := kbfsListTeamsWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	// Iterate through the listed teams in parallel to speed up kssh for users with lots of teams
+	semaphore := sync.WaitGroup{}
+	semaphore.Add(len(allTeamsFromKBFS))
+	boundChan := make(chan interface{}, shared.BoundedParallelismLimit)
+	var mu sync.Mutex
+	var paths []string
+	for _, team := range allTeamsFromKBFS {
+		go func(team string) {
+			boundChan <- 0
+			defer func() { <-boundChan; semaphore.Done() }()
+
+			filename := fmt.Sprintf("/keybase/team/%s/%s", team, shared.ConfigFilename)
+			if exists, err := shared.KBFSFileExists(filename); err == nil && exists {
+				mu.Lock()
+				paths = append(paths, filename)
+				mu.Unlock()
+			}
+			// Treat an error from KBFSFileExists as it not existing and just skip that team
+		}(team)
+	}
+	semaphore.Wait()
+
+	return paths, nil
+}
+
+// kbfsListTeamsWithTimeout lists the user's KBFS teams, bounded by kbfsListTimeout. shared.KBFSList
+// has no context/deadline support of its own, so the call is run in a goroutine and raced against a
+// timer; a timed-out call is abandoned (its goroutine may still finish in the background) rather than
+// left to block the caller indefinitely.
+func kbfsListTeamsWithTimeout() ([]string, error) {
+	type listResult struct {
+		teams []string
+		err   error
+	}
+	resultChan := make(chan listResult, 1)
+	go func() {
+		teams, err := shared.KBFSList("/keybase/team/")
+		resultChan <- listResult{teams: teams, err: err}
+	}()
+
+	select {
+	case res := <-resultChan:
+		if res.err != nil {
+			return nil, fmt.Errorf("failed to list KBFS teams: %v", res.err)
+		}
+		return res.teams, nil
+	case <-time.After(kbfsListTimeout):
+		return nil, fmt.Errorf("timed out after %s listing KBFS teams", kbfsListTimeout)
+	}
+}
+
+func (kbfsConfigSource) Read(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "/keybase/") {
+		return nil, fmt.Errorf("cannot load a kssh config from outside of KBFS")
+	}
+	return shared.KBFSRead(path)
+}
+
+// fileConfigSource lists ConfigFile entries out of a local directory, eg for operators who want to
+// distribute configs via config management rather than KBFS. Constructed from a `file://` URL.
+type fileConfigSource struct {
+	dir string
+}
+
+func newFileConfigSource(rawURL string) *fileConfigSource {
+	return &fileConfigSource{dir: strings.TrimPrefix(rawURL, "file://")}
+}
+
+func (f *fileConfigSource) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config_sources directory %s: %v", f.dir, err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		paths = append(paths, f.dir+"/"+entry.Name())
+	}
+	return paths, nil
+}
+
+func (f *fileConfigSource) Read(path string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return bytes, nil
+}
+
+// httpConfigSource fetches a single JSON document (an array of ConfigFile) from an HTTPS endpoint.
+// It is most useful for operators who run kssh in environments where KBFS is slow or unavailable.
+type httpConfigSource struct {
+	url string
+}
+
+func newHTTPConfigSource(rawURL string) *httpConfigSource {
+	return &httpConfigSource{url: rawURL}
+}
+
+// List always returns the single configured URL; all of the actual config data comes back from Read.
+func (h *httpConfigSource) List() ([]string, error) {
+	return []string{h.url}, nil
+}
+
+func (h *httpConfigSource) Read(path string) ([]byte, error) {
+	client := http.Client{Timeout: httpConfigSourceTimeout}
+	resp, err := client.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config_sources endpoint %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("config_sources endpoint %s returned HTTP %d", path, resp.StatusCode)
+	}
+
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body from %s: %v", path, err)
+	}
+	return bytes, nil
+}
+
+// newConfigSourceFromURL constructs the ConfigSource implementation matching a config_sources URL.
+func newConfigSourceFromURL(rawURL string) (ConfigSource, error) {
+	switch {
+	case strings.HasPrefix(rawURL, "file://"):
+		return newFileConfigSource(rawURL), nil
+	case strings.HasPrefix(rawURL, "https://"):
+		return newHTTPConfigSource(rawURL), nil
+	default:
+		return nil, fmt.Errorf("unsupported config_sources entry %q: must start with file:// or https://", rawURL)
+	}
+}
+
+// getConfigSources returns every ConfigSource kssh should query: KBFS (always, for backwards
+// compatibility) plus whatever extra sources the user's LocalConfigFile lists.
+func getConfigSources() ([]ConfigSource, error) {
+	sources := []ConfigSource{kbfsConfigSource{}}
+
+	lcf, err := getCurrentConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, rawURL := range lcf.ConfigSources {
+		source, err := newConfigSourceFromURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// parseConfigBytes parses the bytes read from a ConfigSource path into one or more ConfigFiles. Most
+// sources produce a single JSON object per path, but httpConfigSource's endpoint returns a JSON array.
+func parseConfigBytes(path string, bytes []byte) ([]ConfigFile, error) {
+	var multi []ConfigFile
+	if err := json.Unmarshal(bytes, &multi); err == nil {
+		return validateConfigs(path, multi)
+	}
+
+	var single ConfigFile
+	if err := json.Unmarshal(bytes, &single); err != nil {
+		return nil, fmt.Errorf("failed to parse config file at %s: %v", path, err)
+	}
+	return validateConfigs(path, []ConfigFile{single})
+}
+
+func validateConfigs(path string, configs []ConfigFile) ([]ConfigFile, error) {
+	for _, cf := range configs {
+		if cf.TeamName == "" || cf.BotName == "" {
+			return nil, fmt.Errorf("found a config file at %s that is missing data: %+v", path, cf)
+		}
+	}
+	return configs, nil
+}
+
+// readConfigsFromSource reads and parses every path listed by source, in parallel (bounded by
+// shared.BoundedParallelismLimit), and returns every ConfigFile found across all of them. A path that
+// fails to read/parse is skipped rather than failing the whole source, so that (eg) one team's
+// unreadable config file doesn't hide every other team's; the source as a whole only fails if every
+// path failed.
+func readConfigsFromSource(source ConfigSource, paths []string) ([]ConfigFile, error) {
+	semaphore := sync.WaitGroup{}
+	semaphore.Add(len(paths))
+	boundChan := make(chan interface{}, shared.BoundedParallelismLimit)
+	errors := make(chan error, len(paths))
+	var mu sync.Mutex
+	var results []ConfigFile
+
+	for _, path := range paths {
+		go func(path string) {
+			boundChan <- 0
+			defer func() { <-boundChan; semaphore.Done() }()
+
+			bytes, err := source.Read(path)
+			if err != nil {
+				errors <- fmt.Errorf("found a config file at %s that could not be read: %v", path, err)
+				return
+			}
+			parsed, err := parseConfigBytes(path, bytes)
+			if err != nil {
+				errors <- err
+				return
+			}
+
+			mu.Lock()
+			results = append(results, parsed...)
+			mu.Unlock()
+		}(path)
+	}
+	semaphore.Wait()
+	close(errors)
+
+	if len(paths) > 0 && len(errors) == len(paths) {
+		// Every single path in this source failed; surface the first error rather than silently
+		// returning an empty result.
+		return nil, <-errors
+	}
+	return results, nil
+}
@@ -0,0 +1,269 @@
+package kssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/bot-ssh-ca/src/shared"
+)
+
+// A HostEntry describes a single bot/team that kssh knows how to reach. It is rendered as a `Host`
+// stanza in the managed ~/.ssh/kssh_config file so that users can run `ssh <alias>` directly rather
+// than having to invoke `kssh <alias>`.
+type HostEntry struct {
+	// Alias is the ssh Host alias. Usually just the bot name.
+	Alias string
+	// HostName is the real hostname to connect to. May be left blank if Alias is already reachable.
+	HostName string
+	// User is the remote ssh user to connect as. May be blank to let ssh fall back to its own default.
+	User string
+	// ProxyCommand is used to shell out to kssh in order to mint a fresh certificate before connecting.
+	// Either ProxyCommand or IdentityFile should be set, not both.
+	ProxyCommand string
+	// IdentityFile points at an already minted certificate/key to use instead of a ProxyCommand.
+	IdentityFile string
+}
+
+const (
+	kesshIncludeBeginMarker = "# BEGIN KSSH MANAGED BLOCK (run `kssh --uninstall` to remove)"
+	kesshIncludeEndMarker   = "# END KSSH MANAGED BLOCK"
+	kesshIncludeDirective   = "Include ~/.ssh/kssh_config"
+)
+
+// kesshConfigFileLocation is the managed file containing one Host stanza per bot/team. It is entirely
+// owned by kssh; any hand edits to it are liable to be clobbered by a future `kssh --install`.
+var kesshConfigFileLocation = shared.ExpandPathWithTilde("~/.ssh/kssh_config")
+
+// mainSSHConfigLocation is the user's own ssh config that we inject a small Include block into.
+var mainSSHConfigLocation = shared.ExpandPathWithTilde("~/.ssh/config")
+
+// WriteSSHConfig renders the given hosts into ~/.ssh/kssh_config and ensures that the user's main
+// ~/.ssh/config includes it. Both files are only touched if their content would actually change.
+func WriteSSHConfig(hosts []HostEntry) error {
+	if err := MakeDotSSH(); err != nil {
+		return err
+	}
+
+	rendered := renderSSHConfig(hosts)
+	if err := writeFileIfChanged(kesshConfigFileLocation, []byte(rendered), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", kesshConfigFileLocation, err)
+	}
+
+	return ensureIncludeInjected()
+}
+
+// RemoveSSHConfig deletes the managed ~/.ssh/kssh_config file and strips the Include block that was
+// injected into the user's main ~/.ssh/config. It is safe to call even if nothing was ever installed.
+func RemoveSSHConfig() error {
+	if _, err := os.Stat(kesshConfigFileLocation); err == nil {
+		if err := os.Remove(kesshConfigFileLocation); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", kesshConfigFileLocation, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %v", kesshConfigFileLocation, err)
+	}
+
+	return removeIncludeBlock()
+}
+
+// DiffSSHConfig returns a unified diff of the changes that WriteSSHConfig(hosts) would make to
+// ~/.ssh/kssh_config, without writing anything to disk. It is used to back `kssh --diff`.
+func DiffSSHConfig(hosts []HostEntry) (string, error) {
+	current, err := readFileOrEmpty(kesshConfigFileLocation)
+	if err != nil {
+		return "", err
+	}
+	rendered := renderSSHConfig(hosts)
+	return unifiedDiff(string(current), rendered, kesshConfigFileLocation), nil
+}
+
+// renderSSHConfig builds the full contents of the managed ~/.ssh/kssh_config file.
+func renderSSHConfig(hosts []HostEntry) string {
+	var sb strings.Builder
+	sb.WriteString("# This file is managed by kssh. Do not edit it by hand; your changes will be overwritten\n")
+	sb.WriteString("# the next time kssh refreshes its list of bots. Run `kssh --uninstall` to remove it.\n\n")
+	for _, host := range hosts {
+		sb.WriteString(fmt.Sprintf("Host %s\n", host.Alias))
+		if host.HostName != "" {
+			sb.WriteString(fmt.Sprintf("    HostName %s\n", host.HostName))
+		}
+		if host.User != "" {
+			sb.WriteString(fmt.Sprintf("    User %s\n", host.User))
+		}
+		if host.IdentityFile != "" {
+			sb.WriteString(fmt.Sprintf("    IdentityFile %s\n", host.IdentityFile))
+		} else if host.ProxyCommand != "" {
+			sb.WriteString(fmt.Sprintf("    ProxyCommand %s\n", host.ProxyCommand))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// ensureIncludeInjected makes sure the user's main ~/.ssh/config contains the managed Include block,
+// inserting it at the very top (ssh uses the first matching directive, so it must come first).
+func ensureIncludeInjected() error {
+	contents, err := readFileOrEmpty(mainSSHConfigLocation)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(string(contents), kesshIncludeBeginMarker) {
+		return nil
+	}
+
+	block := fmt.Sprintf("%s\n%s\n%s\n\n", kesshIncludeBeginMarker, kesshIncludeDirective, kesshIncludeEndMarker)
+	newContents := block + string(contents)
+
+	if err := os.MkdirAll(filepath.Dir(mainSSHConfigLocation), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(mainSSHConfigLocation), err)
+	}
+	if err := writeFileIfChanged(mainSSHConfigLocation, []byte(newContents), 0600); err != nil {
+		return fmt.Errorf("failed to update %s: %v", mainSSHConfigLocation, err)
+	}
+	return nil
+}
+
+// removeIncludeBlock strips the kssh managed block (and only that block) out of the main ssh config.
+func removeIncludeBlock() error {
+	contents, err := readFileOrEmpty(mainSSHConfigLocation)
+	if err != nil {
+		return err
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	var kept []string
+	inBlock := false
+	for _, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == kesshIncludeBeginMarker:
+			inBlock = true
+		case strings.TrimSpace(line) == kesshIncludeEndMarker:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+
+	newContents := strings.Join(kept, "\n")
+	return writeFileIfChanged(mainSSHConfigLocation, []byte(newContents), 0600)
+}
+
+// writeFileIfChanged writes data to path via a temp-file + rename, but only if the existing content
+// differs, so that we don't needlessly bump the mtime (and so --diff has nothing pending afterwards).
+func writeFileIfChanged(path string, data []byte, perm os.FileMode) error {
+	existing, err := readFileOrEmpty(path)
+	if err == nil && string(existing) == string(data) {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, ".kssh-tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file in %s: %v", dir, err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("failed to chmod temp file: %v", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to rename temp file into place: %v", err)
+	}
+	return nil
+}
+
+func readFileOrEmpty(path string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return bytes, nil
+}
+
+// unifiedDiff renders a minimal unified-style diff between two whole-file strings. It is intentionally
+// simple (line-level LCS) rather than pulling in a diff library, since kssh's config files are small.
+func unifiedDiff(oldContent, newContent, label string) string {
+	if oldContent == newContent {
+		return ""
+	}
+	oldLines := strings.Split(oldContent, "\n")
+	newLines := strings.Split(newContent, "\n")
+
+	lcs := longestCommonSubsequence(oldLines, newLines)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", label, label))
+	oi, ni, li := 0, 0, 0
+	for oi < len(oldLines) || ni < len(newLines) {
+		if li < len(lcs) && oi < len(oldLines) && ni < len(newLines) && oldLines[oi] == lcs[li] && newLines[ni] == lcs[li] {
+			sb.WriteString(fmt.Sprintf(" %s\n", oldLines[oi]))
+			oi++
+			ni++
+			li++
+			continue
+		}
+		if oi < len(oldLines) && (li >= len(lcs) || oldLines[oi] != lcs[li]) {
+			sb.WriteString(fmt.Sprintf("-%s\n", oldLines[oi]))
+			oi++
+			continue
+		}
+		if ni < len(newLines) && (li >= len(lcs) || newLines[ni] != lcs[li]) {
+			sb.WriteString(fmt.Sprintf("+%s\n", newLines[ni]))
+			ni++
+			continue
+		}
+	}
+	return sb.String()
+}
+
+// longestCommonSubsequence returns the longest common subsequence of lines between a and b.
+func longestCommonSubsequence(a, b []string) []string {
+	n, m := len(a), len(b)
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	var result []string
+	i, j := 0, 0
+	for i < n && j < m {
+		if a[i] == b[j] {
+			result = append(result, a[i])
+			i++
+			j++
+		} else if dp[i+1][j] >= dp[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+package kssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSSHConfig(t *testing.T) {
+	hosts := []HostEntry{
+		{Alias: "bot1", HostName: "bot1.example.com", User: "ubuntu", ProxyCommand: "kssh --proxy bot1"},
+		{Alias: "bot2", IdentityFile: "~/.ssh/bot2-cert.pub"},
+		{Alias: "bot3"},
+	}
+
+	rendered := renderSSHConfig(hosts)
+
+	for _, want := range []string{
+		"Host bot1",
+		"HostName bot1.example.com",
+		"User ubuntu",
+		"ProxyCommand kssh --proxy bot1",
+		"Host bot2",
+		"IdentityFile ~/.ssh/bot2-cert.pub",
+		"Host bot3",
+	} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("renderSSHConfig output missing %q, got:\n%s", want, rendered)
+		}
+	}
+
+	// IdentityFile and ProxyCommand are mutually exclusive; IdentityFile should win when both are set.
+	both := renderSSHConfig([]HostEntry{{Alias: "bot4", IdentityFile: "cert", ProxyCommand: "cmd"}})
+	if strings.Contains(both, "ProxyCommand") {
+		t.Errorf("renderSSHConfig should prefer IdentityFile over ProxyCommand, got:\n%s", both)
+	}
+}
+
+func TestUnifiedDiffNoChange(t *testing.T) {
+	if diff := unifiedDiff("same\ncontent\n", "same\ncontent\n", "f"); diff != "" {
+		t.Errorf("expected no diff for identical content, got:\n%s", diff)
+	}
+}
+
+func TestUnifiedDiffAddedAndRemovedLines(t *testing.T) {
+	old := "Host a\n    HostName a.example.com\n\nHost b\n"
+	new := "Host a\n    HostName a.example.com\n\nHost c\n"
+
+	diff := unifiedDiff(old, new, "kssh_config")
+
+	if !strings.Contains(diff, "-Host b") {
+		t.Errorf("expected diff to mark removed line, got:\n%s", diff)
+	}
+	if !strings.Contains(diff, "+Host c") {
+		t.Errorf("expected diff to mark added line, got:\n%s", diff)
+	}
+	if !strings.Contains(diff, " Host a") {
+		t.Errorf("expected diff to keep unchanged line, got:\n%s", diff)
+	}
+}
+
+func TestLongestCommonSubsequence(t *testing.T) {
+	a := []string{"one", "two", "three", "four"}
+	b := []string{"zero", "two", "four", "five"}
+
+	lcs := longestCommonSubsequence(a, b)
+	want := []string{"two", "four"}
+
+	if len(lcs) != len(want) {
+		t.Fatalf("longestCommonSubsequence(%v, %v) = %v, want %v", a, b, lcs, want)
+	}
+	for i := range want {
+		if lcs[i] != want[i] {
+			t.Fatalf("longestCommonSubsequence(%v, %v) = %v, want %v", a, b, lcs, want)
+		}
+	}
+}